@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	tibber "github.com/tskaard/tibber-golang"
+)
+
+// accumulatedPublishInterval throttles how often the accumulated energy/cost
+// counters are republished. They move slowly and dashboards sample them far
+// less often than LiveMeasurement arrives (every few seconds for Watty-style
+// meters), so republishing on every frame is unnecessarily chatty.
+const accumulatedPublishInterval = 1 * time.Minute
+
+// homeEnergyState tracks the accumulated-consumption counter across midnight
+// rollovers so lifetime totals can be derived even though Tibber resets
+// accumulatedConsumption/accumulatedProduction at the start of each day.
+// lifetimeTotal is consumption only (production is excluded) so it stays a
+// monotonically increasing total rather than a net import/export figure.
+type homeEnergyState struct {
+	mu              sync.Mutex
+	day             string
+	lastAccumulated float64
+	lifetimeTotal   float64
+	lastPublishAt   time.Time
+}
+
+// sendAccumulatedMeterMsgs publishes the daily accumulated energy and cost
+// counters as standard FIMP meter/sensor reports, throttled to
+// accumulatedPublishInterval, and feeds the accumulated-consumption counter
+// into the midnight-rollover lifetime total. Accumulated cost is published
+// on its own service rather than sensor_price, since it is a different
+// quantity (a running daily total) from the instantaneous spot price
+// sensor_price already carries.
+func (th *TibberHandler) sendAccumulatedMeterMsgs(homeID string, liveData tibber.LiveMeasurement) {
+	if th.shouldPublishAccumulated(homeID) {
+		energy := liveData.AccumulatedConsumption - liveData.AccumulatedProduction
+		th.sendMeterReportMsg(homeID, energy, "kWh", nil)
+		cost := liveData.AccumulatedCost - liveData.AccumulatedReward
+		currency := liveData.Currency
+		if currency == "" {
+			currency = "NOK"
+		}
+		th.sendSensorReportMsg(homeID, "sensor_price_accumulated", cost, currency+"/day", nil)
+	}
+	th.trackDailyEnergy(homeID, liveData.AccumulatedConsumption)
+}
+
+// shouldPublishAccumulated reports whether enough time has passed since the
+// last accumulated energy/cost publish for homeID, and records the attempt.
+func (th *TibberHandler) shouldPublishAccumulated(homeID string) bool {
+	th.mu.RLock()
+	state, ok := th.energyStates[homeID]
+	th.mu.RUnlock()
+	if !ok {
+		return true
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if !state.lastPublishAt.IsZero() && time.Since(state.lastPublishAt) < accumulatedPublishInterval {
+		return false
+	}
+	state.lastPublishAt = time.Now()
+	return true
+}
+
+// trackDailyEnergy detects a day boundary for a home and, when one occurs,
+// folds the previous day's final accumulated-consumption reading into the
+// lifetime total before it gets reset by Tibber. The per-home state is
+// pre-populated in startHomeStream, so this only ever reads th.energyStates.
+func (th *TibberHandler) trackDailyEnergy(homeID string, accumulatedConsumption float64) {
+	th.mu.RLock()
+	state, ok := th.energyStates[homeID]
+	th.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	today := time.Now().Format("2006-01-02")
+	if state.day == "" {
+		state.day = today
+		state.lastAccumulated = accumulatedConsumption
+		return
+	}
+	if today != state.day {
+		state.lifetimeTotal += state.lastAccumulated
+		state.day = today
+		log.Infof("<tibber> home %s rolled over to %s, lifetime total now %.3f kWh", homeID, today, state.lifetimeTotal)
+		th.sendMeterTotalReportMsg(homeID, state.lifetimeTotal)
+	}
+	state.lastAccumulated = accumulatedConsumption
+}
+
+// sendMeterTotalReportMsg fans the lifetime energy total out to every
+// configured Publisher, same as sendMeterReportMsg, so the MQTT-JSON and
+// InfluxDB sinks receive it alongside FIMP.
+func (th *TibberHandler) sendMeterTotalReportMsg(addr string, value float64) {
+	service := "meter_elec_total"
+	for _, p := range th.publishers {
+		if err := p.PublishMeter(addr, service, value, "kWh", nil); err != nil {
+			log.WithError(err).Error("Could not publish meter total report")
+		}
+	}
+}
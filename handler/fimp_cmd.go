@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"github.com/futurehomeno/fimpgo"
+	log "github.com/sirupsen/logrus"
+	tibber "github.com/tskaard/tibber-golang"
+)
+
+// StartFimpCmdListener listens for inbound FIMP commands on the adapter's
+// command topic and routes them to routeFimpCmd. This lets the Futurehome
+// app trigger on-demand refreshes instead of waiting for the regular tickers.
+func (th *TibberHandler) StartFimpCmdListener() {
+	go func() {
+		for msg := range th.inMsgChan {
+			th.routeFimpCmd(msg)
+		}
+	}()
+}
+
+// routeFimpCmd dispatches an inbound command message to the right handler.
+func (th *TibberHandler) routeFimpCmd(newMsg *fimpgo.Message) {
+	switch newMsg.Payload.Type {
+	case "cmd.auth.set_tokens":
+		th.handleSetTokens(newMsg)
+	case "cmd.config.get_extended_report":
+		th.sendConfigExtendedReportMsg(newMsg.Payload)
+	case "cmd.meter.get_report":
+		th.handleGetMeterReport(newMsg)
+	case "cmd.sensor.get_report":
+		th.handleGetReport(newMsg)
+	case "cmd.thing.get_inclusion_report":
+		th.handleGetInclusionReport(newMsg)
+	default:
+		log.Debug("<tibber> unsupported cmd type - ", newMsg.Payload.Type)
+	}
+}
+
+// handleSetTokens updates the Tibber access token at runtime, without
+// requiring the app to be restarted.
+func (th *TibberHandler) handleSetTokens(newMsg *fimpgo.Message) {
+	token, err := newMsg.Payload.GetStringValue()
+	if err != nil {
+		log.Error("<tibber> cmd.auth.set_tokens had no string value - ", err)
+		return
+	}
+	th.client.Token = token
+	th.mu.Lock()
+	th.token = token
+	th.mu.Unlock()
+	for _, stream := range th.streamsSnapshot() {
+		stream.Token = token
+	}
+	log.Info("<tibber> access token updated at runtime")
+}
+
+// handleGetReport forces an immediate poll of the current price, either for
+// the home addressed in the message or, if no address is given, for all
+// configured homes.
+func (th *TibberHandler) handleGetReport(newMsg *fimpgo.Message) {
+	homeID := newMsg.Addr.ServiceAddress
+	if homeID != "" {
+		th.publishCurrentPrice(homeID, newMsg.Payload)
+		return
+	}
+	for _, id := range th.homeIDs() {
+		th.publishCurrentPrice(id, newMsg.Payload)
+	}
+}
+
+func (th *TibberHandler) publishCurrentPrice(homeID string, oldMsg *fimpgo.FimpMessage) {
+	currentPrice, err := th.client.GetCurrentPrice(homeID)
+	if err != nil {
+		log.Error("<tibber> error getting current price - ", err)
+		return
+	}
+	th.sendSensorReportMsg(homeID, "sensor_price", currentPrice.Total, currentPrice.Currency, oldMsg)
+}
+
+// handleGetMeterReport forces an immediate republish of the last known meter
+// reading, either for the home addressed in the message or, if no address is
+// given, for all configured homes. Unlike cmd.sensor.get_report, this must
+// answer with a meter-shaped report rather than the spot price.
+func (th *TibberHandler) handleGetMeterReport(newMsg *fimpgo.Message) {
+	homeID := newMsg.Addr.ServiceAddress
+	if homeID != "" {
+		th.publishCachedMeterReport(homeID, newMsg.Payload)
+		return
+	}
+	for _, id := range th.homeIDs() {
+		th.publishCachedMeterReport(id, newMsg.Payload)
+	}
+}
+
+func (th *TibberHandler) publishCachedMeterReport(homeID string, oldMsg *fimpgo.FimpMessage) {
+	watt, havePower, extended := th.cachedMeterReading(homeID)
+	if !havePower && len(extended) == 0 {
+		log.Debug("<tibber> cmd.meter.get_report: no meter reading cached yet for home ", homeID)
+		th.sendErrorReportMsg(homeID, "no_meter_reading_cached", "no meter reading received yet for this home", oldMsg)
+		return
+	}
+	if havePower {
+		th.sendMeterReportMsg(homeID, watt, "W", oldMsg)
+	}
+	if len(extended) > 0 {
+		th.sendMeterExtendedReportMsg(homeID, extended, oldMsg)
+	}
+}
+
+// handleGetInclusionReport re-emits the inclusion report for the home
+// addressed in the message, or for all configured homes if no address is
+// given.
+func (th *TibberHandler) handleGetInclusionReport(newMsg *fimpgo.Message) {
+	homeID := newMsg.Addr.ServiceAddress
+	if homeID != "" {
+		if home, ok := th.homeByID(homeID); ok {
+			th.sendInclusionReportMsg(home, newMsg.Payload)
+		}
+		return
+	}
+	for _, home := range th.homesSnapshot() {
+		th.sendInclusionReportMsg(home, newMsg.Payload)
+	}
+}
+
+// sendConfigExtendedReportMsg lists the homes currently configured on this
+// adapter instance.
+func (th *TibberHandler) sendConfigExtendedReportMsg(oldMsg *fimpgo.FimpMessage) {
+	homeList := th.homesSnapshot()
+	homes := make([]map[string]string, 0, len(homeList))
+	for _, home := range homeList {
+		homes = append(homes, map[string]string{"id": home.ID, "address": home.Address.Address1})
+	}
+	report := map[string]interface{}{"homes": homes}
+	msg := fimpgo.NewMessage("evt.config.extended_report", "config", "object", report, nil, nil, oldMsg)
+	adr, _ := fimpgo.NewAddressFromString("pt:j1/mt:evt/rt:ad/rn:tibber/ad:1")
+	if err := th.mqtt.Publish(adr, msg); err != nil {
+		log.WithError(err).Error("Could not publish MQTT message")
+	}
+}
+
+// sendInclusionReportMsg emits an inclusion report for a single home so it
+// shows up as its own device in Futurehome.
+func (th *TibberHandler) sendInclusionReportMsg(home *tibber.Home, oldMsg *fimpgo.FimpMessage) {
+	report := map[string]interface{}{
+		"address":    home.ID,
+		"type":       "tibber_home",
+		"product_id": home.AppNickname,
+		"services":   []string{"sensor_price", "sensor_price_accumulated", "sensor_price_level", "price_forecast", "meter_elec"},
+	}
+	msg := fimpgo.NewMessage("evt.thing.inclusion_report", "tibber", "object", report, nil, nil, oldMsg)
+	adr, _ := fimpgo.NewAddressFromString("pt:j1/mt:evt/rt:ad/rn:tibber/ad:1/ad:" + home.ID)
+	if err := th.mqtt.Publish(adr, msg); err != nil {
+		log.WithError(err).Error("Could not publish MQTT message")
+	}
+}
+
+// sendExclusionReportMsg emits an exclusion report for a home that has
+// disappeared from the account since the last refresh.
+func (th *TibberHandler) sendExclusionReportMsg(home *tibber.Home) {
+	report := map[string]interface{}{"address": home.ID}
+	msg := fimpgo.NewMessage("evt.thing.exclusion_report", "tibber", "object", report, nil, nil, nil)
+	adr, _ := fimpgo.NewAddressFromString("pt:j1/mt:evt/rt:ad/rn:tibber/ad:1/ad:" + home.ID)
+	if err := th.mqtt.Publish(adr, msg); err != nil {
+		log.WithError(err).Error("Could not publish MQTT message")
+	}
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/futurehomeno/fimpgo"
+	"github.com/futurehomeno/fimpgo/edgeapp"
+	log "github.com/sirupsen/logrus"
+	tibber "github.com/tskaard/tibber-golang"
+)
+
+// priceForecastPollInterval controls how often we check whether it's time to
+// refresh the day-ahead schedule. Tibber publishes tomorrow's prices around
+// 13:00 CET, so polling every few minutes is enough to catch it quickly.
+const priceForecastPollInterval = 10 * time.Minute
+
+// PriceScheduleEntry is a single hourly price point in the published schedule.
+type PriceScheduleEntry struct {
+	StartsAt string  `json:"startsAt"`
+	Total    float64 `json:"total"`
+	Energy   float64 `json:"energy"`
+	Tax      float64 `json:"tax"`
+	Currency string  `json:"currency"`
+	Level    string  `json:"level"`
+}
+
+// startPriceForecastPolling sets up a ticker that checks every few minutes
+// whether a new day of prices has become available and, if so, republishes
+// the full day-ahead schedule (typically once around 13:00 CET for
+// tomorrow's prices). The current price level is refreshed on every poll
+// regardless, since it changes hour to hour within an already-published
+// schedule.
+func (th *TibberHandler) startPriceForecastPolling() {
+	th.fetchAndPublishPriceSchedules()
+	th.priceForecastTicker = time.NewTicker(priceForecastPollInterval)
+	go func() {
+		for range th.priceForecastTicker.C {
+			if th.appLifecycle.AppState() != edgeapp.AppStateRunning {
+				continue
+			}
+			th.fetchAndPublishPriceSchedules()
+		}
+	}()
+}
+
+// fetchAndPublishPriceSchedules refreshes the day-ahead price schedule for
+// every configured home.
+func (th *TibberHandler) fetchAndPublishPriceSchedules() {
+	for _, homeID := range th.homeIDs() {
+		th.fetchAndPublishPriceSchedule(homeID)
+	}
+}
+
+// fetchAndPublishPriceSchedule fetches today's and tomorrow's price info from
+// Tibber for a single home, republishes the full schedule only if a new day
+// of prices has arrived since the last publish, and always refreshes the
+// current price level.
+func (th *TibberHandler) fetchAndPublishPriceSchedule(homeID string) {
+	home, err := th.client.GetHomeById(homeID)
+	if err != nil {
+		log.Error("<tibber> error getting price info - ", err)
+		return
+	}
+	schedule := buildPriceSchedule(home)
+	if len(schedule) == 0 {
+		log.Debug("<tibber> no price schedule available yet")
+		return
+	}
+	if th.scheduleChanged(homeID, schedule[len(schedule)-1].StartsAt) {
+		th.sendPriceScheduleReportMsg(homeID, schedule)
+	}
+	if level := currentPriceLevel(schedule); level != "" {
+		th.sendPriceLevelSensorMsg(homeID, level)
+	}
+}
+
+// scheduleChanged reports whether end (the StartsAt of the schedule's last
+// entry) differs from what was last published for homeID, and records it as
+// the last published end when it does.
+func (th *TibberHandler) scheduleChanged(homeID string, end string) bool {
+	th.scheduleEndMu.Lock()
+	defer th.scheduleEndMu.Unlock()
+	if th.scheduleEnds == nil {
+		th.scheduleEnds = make(map[string]string)
+	}
+	if th.scheduleEnds[homeID] == end {
+		return false
+	}
+	th.scheduleEnds[homeID] = end
+	return true
+}
+
+// buildPriceSchedule flattens today's and tomorrow's price info into a single
+// chronologically ordered schedule.
+func buildPriceSchedule(home tibber.Home) []PriceScheduleEntry {
+	prices := append([]tibber.Price{}, home.CurrentSubscription.PriceInfo.Today...)
+	prices = append(prices, home.CurrentSubscription.PriceInfo.Tomorrow...)
+	schedule := make([]PriceScheduleEntry, 0, len(prices))
+	for _, p := range prices {
+		schedule = append(schedule, PriceScheduleEntry{
+			StartsAt: p.StartsAt,
+			Total:    p.Total,
+			Energy:   p.Energy,
+			Tax:      p.Tax,
+			Currency: p.Currency,
+			Level:    p.Level,
+		})
+	}
+	return schedule
+}
+
+// currentPriceLevel returns the level of the schedule entry whose StartsAt is
+// the most recent one not in the future.
+func currentPriceLevel(schedule []PriceScheduleEntry) string {
+	now := time.Now()
+	level := ""
+	for _, entry := range schedule {
+		startsAt, err := time.Parse(time.RFC3339, entry.StartsAt)
+		if err != nil {
+			continue
+		}
+		if startsAt.After(now) {
+			break
+		}
+		level = entry.Level
+	}
+	return level
+}
+
+func (th *TibberHandler) sendPriceScheduleReportMsg(addr string, schedule []PriceScheduleEntry) {
+	service := "price_forecast"
+	msg := fimpgo.NewMessage("evt.price_schedule.report", service, "object", schedule, nil, nil, nil)
+	adr, _ := fimpgo.NewAddressFromString("pt:j1/mt:evt/rt:dev/rn:tibber/ad:1/sv:" + service + "/ad:" + addr)
+	if err := th.mqtt.Publish(adr, msg); err != nil {
+		log.WithError(err).Error("Could not publish MQTT message")
+	}
+}
+
+func (th *TibberHandler) sendPriceLevelSensorMsg(addr string, level string) {
+	service := "sensor_price_level"
+	msg := fimpgo.NewMessage("evt.sensor.report", service, "string", level, nil, nil, nil)
+	adr, _ := fimpgo.NewAddressFromString("pt:j1/mt:evt/rt:dev/rn:tibber/ad:1/sv:" + service + "/ad:" + addr)
+	if err := th.mqtt.Publish(adr, msg); err != nil {
+		log.WithError(err).Error("Could not publish MQTT message")
+	}
+}
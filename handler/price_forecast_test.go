@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	tibber "github.com/tskaard/tibber-golang"
+)
+
+func TestBuildPriceSchedule(t *testing.T) {
+	home := tibber.Home{}
+	home.CurrentSubscription.PriceInfo.Today = []tibber.Price{
+		{StartsAt: "2026-07-27T00:00:00+02:00", Total: 1.1, Energy: 0.9, Tax: 0.2, Currency: "NOK", Level: "NORMAL"},
+	}
+	home.CurrentSubscription.PriceInfo.Tomorrow = []tibber.Price{
+		{StartsAt: "2026-07-28T00:00:00+02:00", Total: 1.5, Energy: 1.2, Tax: 0.3, Currency: "NOK", Level: "EXPENSIVE"},
+	}
+
+	schedule := buildPriceSchedule(home)
+
+	if len(schedule) != 2 {
+		t.Fatalf("len(schedule) = %d, want 2", len(schedule))
+	}
+	if schedule[0].StartsAt != home.CurrentSubscription.PriceInfo.Today[0].StartsAt {
+		t.Errorf("schedule[0] = %+v, want today's entry first", schedule[0])
+	}
+	if schedule[1].StartsAt != home.CurrentSubscription.PriceInfo.Tomorrow[0].StartsAt {
+		t.Errorf("schedule[1] = %+v, want tomorrow's entry second", schedule[1])
+	}
+}
+
+func TestCurrentPriceLevel(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-2 * time.Hour).Format(time.RFC3339)
+	recent := now.Add(-1 * time.Hour).Format(time.RFC3339)
+	future := now.Add(1 * time.Hour).Format(time.RFC3339)
+
+	cases := []struct {
+		name     string
+		schedule []PriceScheduleEntry
+		want     string
+	}{
+		{
+			name:     "empty schedule has no level",
+			schedule: nil,
+			want:     "",
+		},
+		{
+			name: "picks the most recent non-future entry",
+			schedule: []PriceScheduleEntry{
+				{StartsAt: past, Level: "CHEAP"},
+				{StartsAt: recent, Level: "NORMAL"},
+				{StartsAt: future, Level: "EXPENSIVE"},
+			},
+			want: "NORMAL",
+		},
+		{
+			name: "all entries in the future yields no level",
+			schedule: []PriceScheduleEntry{
+				{StartsAt: future, Level: "EXPENSIVE"},
+			},
+			want: "",
+		},
+		{
+			name: "unparsable entries are skipped",
+			schedule: []PriceScheduleEntry{
+				{StartsAt: "not-a-timestamp", Level: "CHEAP"},
+				{StartsAt: past, Level: "NORMAL"},
+			},
+			want: "NORMAL",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := currentPriceLevel(tc.schedule); got != tc.want {
+				t.Errorf("currentPriceLevel() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
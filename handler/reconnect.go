@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	tibber "github.com/tskaard/tibber-golang"
+)
+
+const (
+	reconnectBaseDelay      = 1 * time.Second
+	reconnectMaxDelay       = 5 * time.Minute
+	reconnectErrorThreshold = 5
+
+	watchdogCheckInterval = 15 * time.Second
+	// watchdogWindowDefault is used for regular (Pulse) meters.
+	watchdogWindowDefault = 2 * time.Minute
+	// watchdogWindowFastMeter is used once a home has been observed sending
+	// extended measurements (Watty-style meters report much more often).
+	watchdogWindowFastMeter = 30 * time.Second
+)
+
+// homeStreamState tracks reconnection and watchdog bookkeeping for a single
+// home's real-time subscription.
+type homeStreamState struct {
+	mu                sync.Mutex
+	lastMeasurementAt time.Time
+	reconnectAttempts int
+	fastMeter         bool
+	reconnecting      bool
+
+	// Last known meter reading, cached so cmd.meter.get_report can force an
+	// immediate republish without waiting for the next live measurement.
+	havePower    bool
+	lastPowerW   float64
+	lastExtended map[string]float64
+
+	// msgChan is the channel the current message-consumer goroutine is
+	// ranging over, so a reconnect can close the previous one and let its
+	// goroutine exit instead of leaking it.
+	msgChan tibber.MsgChan
+}
+
+// replaceMsgChan stores newChan as the live message channel for a home and
+// closes whatever channel was previously registered, letting the consumer
+// goroutine that was ranging over it exit. Must be called after the stream's
+// previous subscription has been stopped, so nothing is still writing to the
+// old channel.
+func (th *TibberHandler) replaceMsgChan(homeID string, newChan tibber.MsgChan) {
+	th.mu.RLock()
+	state, ok := th.streamStates[homeID]
+	th.mu.RUnlock()
+	if !ok {
+		return
+	}
+	state.mu.Lock()
+	old := state.msgChan
+	state.msgChan = newChan
+	state.mu.Unlock()
+	if old != nil {
+		close(old)
+	}
+}
+
+// recordMeterReading caches the most recent meter values seen for a home's
+// stream, so an on-demand cmd.meter.get_report can republish them.
+func (th *TibberHandler) recordMeterReading(homeID string, watt float64, hasPower bool, extended map[string]float64) {
+	th.mu.RLock()
+	state, ok := th.streamStates[homeID]
+	th.mu.RUnlock()
+	if !ok {
+		return
+	}
+	state.mu.Lock()
+	if hasPower {
+		state.havePower = true
+		state.lastPowerW = watt
+	}
+	if extended != nil {
+		state.lastExtended = extended
+	}
+	state.mu.Unlock()
+}
+
+// cachedMeterReading returns the last meter values recorded for a home, if
+// any.
+func (th *TibberHandler) cachedMeterReading(homeID string) (watt float64, havePower bool, extended map[string]float64) {
+	th.mu.RLock()
+	state, ok := th.streamStates[homeID]
+	th.mu.RUnlock()
+	if !ok {
+		return 0, false, nil
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.lastPowerW, state.havePower, state.lastExtended
+}
+
+// recordMeasurement updates the last-seen timestamp for a home's stream and
+// remembers whether it reports extended (fast-cycle) measurements.
+func (th *TibberHandler) recordMeasurement(homeID string, extended bool) {
+	th.mu.RLock()
+	state, ok := th.streamStates[homeID]
+	th.mu.RUnlock()
+	if !ok {
+		return
+	}
+	state.mu.Lock()
+	state.lastMeasurementAt = time.Now()
+	if extended {
+		state.fastMeter = true
+	}
+	state.mu.Unlock()
+}
+
+// resetReconnectAttempts clears the backoff counter once a home's stream has
+// reconnected successfully.
+func (th *TibberHandler) resetReconnectAttempts(homeID string) {
+	th.mu.RLock()
+	state, ok := th.streamStates[homeID]
+	th.mu.RUnlock()
+	if !ok {
+		return
+	}
+	state.mu.Lock()
+	state.reconnectAttempts = 0
+	state.mu.Unlock()
+}
+
+// reconnectHomeStream stops and restarts a home's real-time subscription
+// with an exponential backoff (1s, 2s, 4s... capped at 5 min, with jitter).
+// It is called both from the stream's own disconnect event and from the
+// watchdog, so it guards against overlapping reconnects for the same home:
+// if one is already in flight (e.g. still sleeping through its backoff) a
+// second call is a no-op.
+func (th *TibberHandler) reconnectHomeStream(homeID string) {
+	th.mu.RLock()
+	stream, streamOk := th.streams[homeID]
+	state, stateOk := th.streamStates[homeID]
+	th.mu.RUnlock()
+	if !streamOk || !stateOk {
+		return
+	}
+
+	state.mu.Lock()
+	if state.reconnecting {
+		state.mu.Unlock()
+		return
+	}
+	state.reconnecting = true
+	state.reconnectAttempts++
+	attempt := state.reconnectAttempts
+	state.mu.Unlock()
+
+	defer func() {
+		state.mu.Lock()
+		state.reconnecting = false
+		state.mu.Unlock()
+	}()
+
+	delay := reconnectBackoffDelay(attempt)
+	log.Warnf("<tibber> home %s disconnected, reconnecting in %s (attempt %d)", homeID, delay, attempt)
+	if attempt == reconnectErrorThreshold {
+		th.sendErrorReportMsg(homeID, "stream_reconnect_failures", "repeated websocket reconnect failures", nil)
+	}
+
+	time.Sleep(delay)
+	stream.StopSubscription()
+	th.openHomeSubscription(homeID, stream)
+}
+
+// reconnectBackoffDelay returns the delay before reconnect attempt n (1-based),
+// doubling from reconnectBaseDelay up to reconnectMaxDelay, with up to 20%
+// jitter to avoid thundering-herd reconnects.
+func reconnectBackoffDelay(attempt int) time.Duration {
+	delay := reconnectBaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= reconnectMaxDelay {
+			delay = reconnectMaxDelay
+			break
+		}
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay) / 5))
+	return delay + jitter
+}
+
+// startWatchdog periodically checks every home's stream for staleness and
+// forces a reconnect if no measurement has arrived within its window.
+func (th *TibberHandler) startWatchdog() {
+	go func() {
+		ticker := time.NewTicker(watchdogCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			th.mu.RLock()
+			states := make(map[string]*homeStreamState, len(th.streamStates))
+			for homeID, state := range th.streamStates {
+				states[homeID] = state
+			}
+			th.mu.RUnlock()
+			for homeID, state := range states {
+				if th.isStreamStale(state) {
+					log.Warn("<tibber> watchdog: no measurement from home ", homeID, " within window, forcing reconnect")
+					go th.reconnectHomeStream(homeID)
+				}
+			}
+		}
+	}()
+}
+
+func (th *TibberHandler) isStreamStale(state *homeStreamState) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.lastMeasurementAt.IsZero() {
+		return false
+	}
+	window := watchdogWindowDefault
+	if state.fastMeter {
+		window = watchdogWindowFastMeter
+	}
+	return time.Since(state.lastMeasurementAt) > window
+}
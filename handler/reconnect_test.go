@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconnectBackoffDelay(t *testing.T) {
+	cases := []struct {
+		name    string
+		attempt int
+		want    time.Duration
+	}{
+		{"first attempt is base delay", 1, reconnectBaseDelay},
+		{"second attempt doubles", 2, 2 * reconnectBaseDelay},
+		{"third attempt doubles again", 3, 4 * reconnectBaseDelay},
+		{"large attempt caps at max delay", 30, reconnectMaxDelay},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			delay := reconnectBackoffDelay(tc.attempt)
+			if delay < tc.want || delay > tc.want+tc.want/5 {
+				t.Errorf("reconnectBackoffDelay(%d) = %s, want in [%s, %s]", tc.attempt, delay, tc.want, tc.want+tc.want/5)
+			}
+		})
+	}
+}
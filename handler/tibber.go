@@ -1,12 +1,15 @@
 package handler
 
 import (
+	"sync"
 	"time"
 
 	"github.com/futurehomeno/fimpgo"
 	"github.com/futurehomeno/fimpgo/edgeapp"
 	log "github.com/sirupsen/logrus"
 	tibber "github.com/tskaard/tibber-golang"
+
+	"github.com/thingsplex/fh-tibber/publisher"
 )
 
 // AuthData is used to store all the tokens and expire information
@@ -22,73 +25,248 @@ type AuthData struct {
 type TibberHandler struct {
 	mqtt   *fimpgo.MqttTransport
 	client *tibber.Client
-	stream *tibber.Stream
-	//streams      map[string]*tibber.Stream
-	msgChan      tibber.MsgChan
-	ticker       *time.Ticker
-	home         *tibber.Home
-	appLifecycle *edgeapp.Lifecycle
+	token  string
+
+	// mu guards homes, streams, streamStates and energyStates: they're written from
+	// refreshHomes/startHomeStream/stopHomeStream and read concurrently by
+	// the message-routing, polling and watchdog goroutines.
+	mu                  sync.RWMutex
+	homes               map[string]*tibber.Home
+	streams             map[string]*tibber.Stream
+	streamStates        map[string]*homeStreamState
+	energyStates        map[string]*homeEnergyState
+	inMsgChan           fimpgo.MessageCh
+	ticker              *time.Ticker
+	priceForecastTicker *time.Ticker
+	homesRefreshTicker  *time.Ticker
+	scheduleEndMu       sync.Mutex
+	scheduleEnds        map[string]string
+	appLifecycle        *edgeapp.Lifecycle
+	publishers          []publisher.Publisher
 }
 
-// NewTibberHandler construct new handler
-func NewTibberHandler(transport *fimpgo.MqttTransport, appLifecycle *edgeapp.Lifecycle) *TibberHandler {
+// fimpCmdTopic is the inbound topic the adapter listens on for commands from
+// the Futurehome app/UI.
+const fimpCmdTopic = "pt:j1/mt:cmd/rt:ad/rn:tibber/ad:1"
+
+// NewTibberHandler construct new handler. extraPublishers are additional
+// output sinks (e.g. MQTT-JSON, InfluxDB) enabled via the app's config; the
+// FIMP publisher is always included and does not need to be passed in.
+func NewTibberHandler(transport *fimpgo.MqttTransport, appLifecycle *edgeapp.Lifecycle, extraPublishers ...publisher.Publisher) *TibberHandler {
 	th := &TibberHandler{
 		mqtt:         transport,
 		appLifecycle: appLifecycle,
 		client:       tibber.NewClient(""),
-		msgChan:      make(tibber.MsgChan),
-		home:         &tibber.Home{},
+		homes:        make(map[string]*tibber.Home),
+		streams:      make(map[string]*tibber.Stream),
+		streamStates: make(map[string]*homeStreamState),
+		energyStates: make(map[string]*homeEnergyState),
+		inMsgChan:    make(fimpgo.MessageCh, 5),
+		publishers:   append([]publisher.Publisher{publisher.NewFimpPublisher(transport)}, extraPublishers...),
+	}
+	th.mqtt.RegisterChannel("tibber-cmd", th.inMsgChan)
+	if err := th.mqtt.Subscribe(fimpCmdTopic); err != nil {
+		log.Error("<tibber> error subscribing to cmd topic - ", err)
 	}
-	th.stream = tibber.NewStream("", "")
-	th.StartStreamStateEventListener()
+	th.StartFimpCmdListener()
+	th.startWatchdog()
 	return th
 }
 
-// Start tibber handler service and listen to ws events
-func (th *TibberHandler) Start(token string, homeID string) error {
-	var err error
-	var home tibber.Home
+// homesRefreshInterval controls how often we re-fetch the home list from
+// Tibber so homes added/removed from the account after startup are picked up
+// without requiring a restart.
+const homesRefreshInterval = 30 * time.Minute
+
+// Start tibber handler service, fetch all homes on the account and start
+// listening to ws events for each of them.
+func (th *TibberHandler) Start(token string) error {
+	th.token = token
 	th.client.Token = token
+	if err := th.refreshHomes(); err != nil {
+		return err
+	}
+	th.startHomesRefreshPolling()
+	th.startPolling()
+	th.startPriceForecastPolling()
+	return nil
+}
+
+// startHomesRefreshPolling sets up a ticker that periodically re-fetches the
+// home list, so a home added to or removed from the account shows up as an
+// inclusion/exclusion report without an adapter restart.
+func (th *TibberHandler) startHomesRefreshPolling() {
+	th.homesRefreshTicker = time.NewTicker(homesRefreshInterval)
+	go func() {
+		for range th.homesRefreshTicker.C {
+			if th.appLifecycle.AppState() != edgeapp.AppStateRunning {
+				continue
+			}
+			if err := th.refreshHomes(); err != nil {
+				log.Error("<tibber> error refreshing homes - ", err)
+			}
+		}
+	}()
+}
+
+// refreshHomes fetches the current list of homes on the account, starts a
+// stream for any home we don't already track and tears down + emits an
+// exclusion report for any home that has disappeared since the last refresh.
+func (th *TibberHandler) refreshHomes() error {
+	var homes []tibber.Home
+	var err error
 	for i := 0; i < 10; i++ {
-		home, err = th.client.GetHomeById(homeID)
+		homes, err = th.client.GetHomes()
 		if err == nil {
 			break
-		} else {
-			log.Error("<tibber> error getting home by id")
-			time.Sleep(60 * time.Second)
 		}
+		log.Error("<tibber> error getting homes - ", err)
+		time.Sleep(60 * time.Second)
 	}
 	if err != nil {
 		return err
 	}
-	log.Info("The Home successfully fetched from Tibber.")
-	th.home = &home
-	// Setting up stream
-	th.stream.Token = token
-	th.stream.ID = th.home.ID
-	th.stream.StartSubscription(th.msgChan)
+	log.Infof("<tibber> %d home(s) successfully fetched from Tibber.", len(homes))
+
+	var added, removed []*tibber.Home
+	seen := make(map[string]bool, len(homes))
+	th.mu.Lock()
+	for i := range homes {
+		home := homes[i]
+		seen[home.ID] = true
+		if _, ok := th.homes[home.ID]; ok {
+			continue
+		}
+		th.homes[home.ID] = &home
+		added = append(added, &home)
+	}
+	for id, home := range th.homes {
+		if seen[id] {
+			continue
+		}
+		removed = append(removed, home)
+		delete(th.homes, id)
+	}
+	th.mu.Unlock()
+
+	for _, home := range added {
+		th.startHomeStream(home)
+		th.sendInclusionReportMsg(home, nil)
+	}
+	for _, home := range removed {
+		th.sendExclusionReportMsg(home)
+		th.stopHomeStream(home.ID)
+	}
+	return nil
+}
+
+// homeIDs returns a snapshot of the currently configured home IDs, safe to
+// range over without holding th.mu.
+func (th *TibberHandler) homeIDs() []string {
+	th.mu.RLock()
+	defer th.mu.RUnlock()
+	ids := make([]string, 0, len(th.homes))
+	for id := range th.homes {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// homesSnapshot returns a copy of the currently configured homes, safe to
+// range over without holding th.mu.
+func (th *TibberHandler) homesSnapshot() []*tibber.Home {
+	th.mu.RLock()
+	defer th.mu.RUnlock()
+	homes := make([]*tibber.Home, 0, len(th.homes))
+	for _, home := range th.homes {
+		homes = append(homes, home)
+	}
+	return homes
+}
+
+// homeByID returns the configured home for id, if any.
+func (th *TibberHandler) homeByID(id string) (*tibber.Home, bool) {
+	th.mu.RLock()
+	defer th.mu.RUnlock()
+	home, ok := th.homes[id]
+	return home, ok
+}
+
+// streamsSnapshot returns a copy of the currently active streams, safe to
+// range over without holding th.mu.
+func (th *TibberHandler) streamsSnapshot() []*tibber.Stream {
+	th.mu.RLock()
+	defer th.mu.RUnlock()
+	streams := make([]*tibber.Stream, 0, len(th.streams))
+	for _, stream := range th.streams {
+		streams = append(streams, stream)
+	}
+	return streams
+}
+
+// startHomeStream opens a real-time subscription for a single home and wires
+// up its own message and state-change listeners.
+func (th *TibberHandler) startHomeStream(home *tibber.Home) {
+	th.mu.RLock()
+	token := th.token
+	th.mu.RUnlock()
+	stream := tibber.NewStream(token, home.ID)
+	th.mu.Lock()
+	th.streams[home.ID] = stream
+	th.streamStates[home.ID] = &homeStreamState{}
+	th.energyStates[home.ID] = &homeEnergyState{}
+	th.mu.Unlock()
+	th.StartStreamStateEventListener(home.ID, stream)
+	th.openHomeSubscription(home.ID, stream)
+}
+
+// openHomeSubscription (re)starts the message subscription for a home's
+// stream and spawns the goroutine that routes incoming measurements. Any
+// previous message channel for this home is closed so its consumer
+// goroutine exits instead of leaking on repeated reconnects.
+func (th *TibberHandler) openHomeSubscription(homeID string, stream *tibber.Stream) {
+	msgChan := make(tibber.MsgChan)
+	th.replaceMsgChan(homeID, msgChan)
+	stream.StartSubscription(msgChan)
 	go func(msgChan tibber.MsgChan) {
-		for {
-			select {
-			case msg := <-msgChan:
-				th.routeTibberMessage(msg)
-			}
+		for msg := range msgChan {
+			th.recordMeasurement(homeID, msg.Payload.Data.LiveMeasurement.IsExtended())
+			th.routeTibberMessage(msg)
 		}
-	}(th.msgChan)
-	th.startPolling()
-	return err
+	}(msgChan)
+}
+
+// stopHomeStream stops the real-time subscription for a home, e.g. when it
+// has been removed from the account.
+func (th *TibberHandler) stopHomeStream(homeID string) {
+	th.mu.Lock()
+	stream, ok := th.streams[homeID]
+	state := th.streamStates[homeID]
+	delete(th.streams, homeID)
+	delete(th.streamStates, homeID)
+	delete(th.energyStates, homeID)
+	th.mu.Unlock()
+	if ok {
+		stream.StopSubscription()
+	}
+	if state != nil && state.msgChan != nil {
+		close(state.msgChan)
+	}
 }
 
-// StartStreamStateEventListener start event listener
-func (th *TibberHandler) StartStreamStateEventListener() {
+// StartStreamStateEventListener starts an event listener for a single home's
+// stream connection state. A disconnect triggers the backoff reconnect logic
+// in reconnect.go.
+func (th *TibberHandler) StartStreamStateEventListener(homeID string, stream *tibber.Stream) {
 	go func() {
-		for {
-			stateMsg := <-th.stream.StateReportChan()
+		for stateMsg := range stream.StateReportChan() {
 			switch stateMsg.State {
 			case tibber.StreamStateConnected:
 				th.appLifecycle.SetConnectionState(edgeapp.ConnStateConnected)
+				th.resetReconnectAttempts(homeID)
 			case tibber.StreamStateDisconnected:
 				th.appLifecycle.SetConnectionState(edgeapp.ConnStateDisconnected)
+				go th.reconnectHomeStream(homeID)
 			}
 		}
 	}()
@@ -103,16 +281,18 @@ func (th *TibberHandler) startPolling() {
 			if time.Now().Minute() >= 5 { // Run ticker only on minutes 0 - 4
 				return
 			}
-			if th.appLifecycle.AppState() == edgeapp.AppStateRunning {
-				currentPrice, err := th.client.GetCurrentPrice(th.home.ID)
+			if th.appLifecycle.AppState() != edgeapp.AppStateRunning {
+				log.Debug("------- NOT CONNECTED -------")
+				continue
+			}
+			for _, homeID := range th.homeIDs() {
+				currentPrice, err := th.client.GetCurrentPrice(homeID)
 				if err != nil {
 					log.Error("Cannot get prices from Tibber - ", err)
-					return
+					continue
 				}
-				th.sendSensorReportMsg(th.home.ID, "sensor_price", currentPrice.Total, currentPrice.Currency, nil)
+				th.sendSensorReportMsg(homeID, "sensor_price", currentPrice.Total, currentPrice.Currency, nil)
 				log.Debug("sensor_price sent")
-			} else {
-				log.Debug("------- NOT CONNECTED -------")
 			}
 		}
 	}()
@@ -120,19 +300,28 @@ func (th *TibberHandler) startPolling() {
 
 func (th *TibberHandler) routeTibberMessage(msg *tibber.StreamMsg) {
 	log.Debug("New tibber msg")
-	if th.home.ID == msg.HomeID {
-		// Chek if measurement has power reading
-		// Should be enough to only send extended report, but app does not use power from extended report yet.
-		// This is a "fix" for Kamstrup that only sends data every 10 sec
-		if msg.Payload.Data.LiveMeasurement.HasProductionOrConsumptionPower() {
-			watt := calculateSinglePowerValue(msg.Payload.Data.LiveMeasurement)
-			th.sendMeterReportMsg(msg.HomeID, float64(watt), "W", nil)
-		}
-		// Check if this is an extended or normal report
-		if msg.Payload.Data.LiveMeasurement.IsExtended() {
-			th.sendMeterExtendedReportMsg(msg.HomeID, msg.Payload.Data.LiveMeasurement.AsFloatMap(), nil)
-		}
+	th.mu.RLock()
+	_, ok := th.homes[msg.HomeID]
+	th.mu.RUnlock()
+	if !ok {
+		return
+	}
+	// Chek if measurement has power reading
+	// Should be enough to only send extended report, but app does not use power from extended report yet.
+	// This is a "fix" for Kamstrup that only sends data every 10 sec
+	hasPower := msg.Payload.Data.LiveMeasurement.HasProductionOrConsumptionPower()
+	watt := calculateSinglePowerValue(msg.Payload.Data.LiveMeasurement)
+	if hasPower {
+		th.sendMeterReportMsg(msg.HomeID, float64(watt), "W", nil)
 	}
+	// Check if this is an extended or normal report
+	var extended map[string]float64
+	if msg.Payload.Data.LiveMeasurement.IsExtended() {
+		extended = msg.Payload.Data.LiveMeasurement.AsFloatMap()
+		th.sendMeterExtendedReportMsg(msg.HomeID, extended, nil)
+	}
+	th.recordMeterReading(msg.HomeID, float64(watt), hasPower, extended)
+	th.sendAccumulatedMeterMsgs(msg.HomeID, msg.Payload.Data.LiveMeasurement)
 }
 
 // calculateSinglePowerValue returns + or - wattage
@@ -146,29 +335,45 @@ func calculateSinglePowerValue(liveData tibber.LiveMeasurement) float64 {
 	return val
 }
 
+// sendSensorReportMsg fans a sensor report out to every configured
+// Publisher (FIMP plus whatever extra sinks are enabled from config).
 func (th *TibberHandler) sendSensorReportMsg(addr string, service string, value float64, unit string, oldMsg *fimpgo.FimpMessage) {
-	props := make(map[string]string)
-	props["unit"] = unit
-	msg := fimpgo.NewMessage("evt.sensor.report", service, "float", value, props, nil, oldMsg)
-	adr, _ := fimpgo.NewAddressFromString("pt:j1/mt:evt/rt:dev/rn:tibber/ad:1/sv:" + service + "/ad:" + addr)
-	th.mqtt.Publish(adr, msg)
+	for _, p := range th.publishers {
+		if err := p.PublishSensor(addr, service, value, unit, oldMsg); err != nil {
+			log.WithError(err).Error("Could not publish sensor report")
+		}
+	}
 }
 
+// sendMeterReportMsg fans a meter report out to every configured Publisher.
 func (th *TibberHandler) sendMeterReportMsg(addr string, value float64, unit string, oldMsg *fimpgo.FimpMessage) {
 	service := "meter_elec"
-	props := make(map[string]string)
-	props["unit"] = unit
-	msg := fimpgo.NewMessage("evt.meter.report", "meter_elec", "float", value, props, nil, oldMsg)
-	adr, _ := fimpgo.NewAddressFromString("pt:j1/mt:evt/rt:dev/rn:tibber/ad:1/sv:" + service + "/ad:" + addr)
-	if err := th.mqtt.Publish(adr, msg); err != nil {
-		log.WithError(err).Error("Could not publish MQTT message")
+	for _, p := range th.publishers {
+		if err := p.PublishMeter(addr, service, value, unit, oldMsg); err != nil {
+			log.WithError(err).Error("Could not publish meter report")
+		}
 	}
 }
 
+// sendMeterExtendedReportMsg fans an extended meter report out to every
+// configured Publisher.
 func (th *TibberHandler) sendMeterExtendedReportMsg(addr string, value map[string]float64, oldMsg *fimpgo.FimpMessage) {
 	service := "meter_elec"
-	msg := fimpgo.NewFloatMapMessage("evt.meter_ext.report", "meter_elec", value, nil, nil, oldMsg)
-	adr, _ := fimpgo.NewAddressFromString("pt:j1/mt:evt/rt:dev/rn:tibber/ad:1/sv:" + service + "/ad:" + addr)
+	for _, p := range th.publishers {
+		if err := p.PublishExtended(addr, service, value, oldMsg); err != nil {
+			log.WithError(err).Error("Could not publish extended meter report")
+		}
+	}
+}
+
+// sendErrorReportMsg notifies the app that something on the adapter side
+// needs attention, e.g. repeated reconnect failures for a home's stream, or
+// an on-demand report that has nothing to answer with yet. oldMsg, if given,
+// correlates the report with the command that asked for it.
+func (th *TibberHandler) sendErrorReportMsg(addr string, errorCode string, msgText string, oldMsg *fimpgo.FimpMessage) {
+	val := map[string]string{"error_code": errorCode, "error_text": msgText}
+	msg := fimpgo.NewMessage("evt.error.report", "tibber", "object", val, nil, nil, oldMsg)
+	adr, _ := fimpgo.NewAddressFromString("pt:j1/mt:evt/rt:ad/rn:tibber/ad:1/ad:" + addr)
 	if err := th.mqtt.Publish(adr, msg); err != nil {
 		log.WithError(err).Error("Could not publish MQTT message")
 	}
@@ -0,0 +1,58 @@
+package publisher
+
+import (
+	"fmt"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Config is the section of the adapter's config file that controls which
+// extra output sinks (besides the always-on FIMP publisher) are enabled.
+type Config struct {
+	MQTTJSON MQTTJSONConfig `json:"mqtt_json"`
+	InfluxDB InfluxDBConfig `json:"influxdb"`
+}
+
+// MQTTJSONConfig configures the plain-JSON MQTT sink.
+type MQTTJSONConfig struct {
+	Enabled     bool   `json:"enabled"`
+	BrokerURI   string `json:"broker_uri"`
+	Username    string `json:"username,omitempty"`
+	Password    string `json:"password,omitempty"`
+	TopicPrefix string `json:"topic_prefix"`
+}
+
+// InfluxDBConfig configures the InfluxDB line-protocol sink.
+type InfluxDBConfig struct {
+	Enabled bool   `json:"enabled"`
+	URL     string `json:"url"`
+	Org     string `json:"org"`
+	Bucket  string `json:"bucket"`
+	Token   string `json:"token"`
+}
+
+// NewPublishersFromConfig constructs the extra Publisher sinks enabled in
+// cfg, for passing to NewTibberHandler as extraPublishers. The FIMP
+// publisher is not part of cfg since NewTibberHandler always adds it.
+func NewPublishersFromConfig(cfg Config) ([]Publisher, error) {
+	var pubs []Publisher
+
+	if cfg.MQTTJSON.Enabled {
+		opts := mqtt.NewClientOptions().AddBroker(cfg.MQTTJSON.BrokerURI)
+		if cfg.MQTTJSON.Username != "" {
+			opts.SetUsername(cfg.MQTTJSON.Username)
+			opts.SetPassword(cfg.MQTTJSON.Password)
+		}
+		client := mqtt.NewClient(opts)
+		if token := client.Connect(); token.Wait() && token.Error() != nil {
+			return nil, fmt.Errorf("mqtt_json: connecting to %s: %w", cfg.MQTTJSON.BrokerURI, token.Error())
+		}
+		pubs = append(pubs, NewMQTTJSONPublisher(client, cfg.MQTTJSON.TopicPrefix))
+	}
+
+	if cfg.InfluxDB.Enabled {
+		pubs = append(pubs, NewInfluxDBPublisher(cfg.InfluxDB.URL, cfg.InfluxDB.Org, cfg.InfluxDB.Bucket, cfg.InfluxDB.Token))
+	}
+
+	return pubs, nil
+}
@@ -0,0 +1,39 @@
+package publisher
+
+import "github.com/futurehomeno/fimpgo"
+
+// FimpPublisher publishes reports on the standard FIMP topic scheme. It is
+// always enabled and mirrors the adapter's historical publish behaviour.
+type FimpPublisher struct {
+	Mqtt *fimpgo.MqttTransport
+}
+
+// NewFimpPublisher constructs a FimpPublisher.
+func NewFimpPublisher(mqtt *fimpgo.MqttTransport) *FimpPublisher {
+	return &FimpPublisher{Mqtt: mqtt}
+}
+
+func (p *FimpPublisher) PublishMeter(homeID string, service string, value float64, unit string, oldMsg *fimpgo.FimpMessage) error {
+	props := map[string]string{"unit": unit}
+	msg := fimpgo.NewMessage("evt.meter.report", service, "float", value, props, nil, oldMsg)
+	return p.publish(service, homeID, msg)
+}
+
+func (p *FimpPublisher) PublishSensor(homeID string, service string, value float64, unit string, oldMsg *fimpgo.FimpMessage) error {
+	props := map[string]string{"unit": unit}
+	msg := fimpgo.NewMessage("evt.sensor.report", service, "float", value, props, nil, oldMsg)
+	return p.publish(service, homeID, msg)
+}
+
+func (p *FimpPublisher) PublishExtended(homeID string, service string, values map[string]float64, oldMsg *fimpgo.FimpMessage) error {
+	msg := fimpgo.NewFloatMapMessage("evt.meter_ext.report", service, values, nil, nil, oldMsg)
+	return p.publish(service, homeID, msg)
+}
+
+func (p *FimpPublisher) publish(service string, homeID string, msg *fimpgo.FimpMessage) error {
+	adr, err := fimpgo.NewAddressFromString("pt:j1/mt:evt/rt:dev/rn:tibber/ad:1/sv:" + service + "/ad:" + homeID)
+	if err != nil {
+		return err
+	}
+	return p.Mqtt.Publish(adr, msg)
+}
@@ -0,0 +1,93 @@
+package publisher
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/futurehomeno/fimpgo"
+)
+
+// InfluxDBPublisher writes live measurements straight into InfluxDB v2 using
+// the line protocol, so users can graph Tibber data in Grafana without a
+// separate bridge.
+type InfluxDBPublisher struct {
+	HTTPClient *http.Client
+	URL        string // e.g. http://localhost:8086
+	Org        string
+	Bucket     string
+	Token      string
+}
+
+// NewInfluxDBPublisher constructs an InfluxDBPublisher.
+func NewInfluxDBPublisher(url, org, bucket, token string) *InfluxDBPublisher {
+	return &InfluxDBPublisher{
+		HTTPClient: &http.Client{Timeout: 5 * time.Second},
+		URL:        url,
+		Org:        org,
+		Bucket:     bucket,
+		Token:      token,
+	}
+}
+
+func (p *InfluxDBPublisher) PublishMeter(homeID string, service string, value float64, unit string, _ *fimpgo.FimpMessage) error {
+	return p.write(fmt.Sprintf("tibber,home=%s %s=%f %d", homeID, fieldName(service, unit), value, time.Now().UnixNano()))
+}
+
+func (p *InfluxDBPublisher) PublishSensor(homeID string, service string, value float64, unit string, _ *fimpgo.FimpMessage) error {
+	return p.write(fmt.Sprintf("tibber,home=%s %s=%f %d", homeID, fieldName(service, unit), value, time.Now().UnixNano()))
+}
+
+func (p *InfluxDBPublisher) PublishExtended(homeID string, service string, values map[string]float64, _ *fimpgo.FimpMessage) error {
+	if len(values) == 0 {
+		return nil
+	}
+	var fields bytes.Buffer
+	first := true
+	for k, v := range values {
+		if !first {
+			fields.WriteByte(',')
+		}
+		fmt.Fprintf(&fields, "%s=%f", k, v)
+		first = false
+	}
+	return p.write(fmt.Sprintf("tibber,home=%s %s %d", homeID, fields.String(), time.Now().UnixNano()))
+}
+
+func (p *InfluxDBPublisher) write(line string) error {
+	url := fmt.Sprintf("%s/api/v2/write?org=%s&bucket=%s&precision=ns", p.URL, p.Org, p.Bucket)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(line))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+p.Token)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("influxdb write failed with status %s", resp.Status)
+	}
+	return nil
+}
+
+// fieldName maps a FIMP service/unit pair to the InfluxDB field name used in
+// the line protocol, since meter_elec alone carries both instant power (W)
+// and accumulated energy (kWh) readings.
+func fieldName(service, unit string) string {
+	switch {
+	case service == "meter_elec_total":
+		return "energy_total"
+	case service == "sensor_price_accumulated":
+		return "cost"
+	case unit == "W":
+		return "power"
+	case unit == "kWh":
+		return "energy"
+	default:
+		return service
+	}
+}
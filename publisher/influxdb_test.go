@@ -0,0 +1,25 @@
+package publisher
+
+import "testing"
+
+func TestFieldName(t *testing.T) {
+	cases := []struct {
+		name    string
+		service string
+		unit    string
+		want    string
+	}{
+		{"meter total is its own field", "meter_elec_total", "kWh", "energy_total"},
+		{"accumulated price is cost", "sensor_price_accumulated", "NOK/day", "cost"},
+		{"watts are power", "meter_elec", "W", "power"},
+		{"kWh is energy", "meter_elec", "kWh", "energy"},
+		{"unknown unit falls back to service name", "sensor_price", "NOK/kWh", "sensor_price"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := fieldName(tc.service, tc.unit); got != tc.want {
+				t.Errorf("fieldName(%q, %q) = %q, want %q", tc.service, tc.unit, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package publisher
+
+import (
+	"encoding/json"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/futurehomeno/fimpgo"
+)
+
+// MQTTJSONPublisher publishes plain JSON payloads on a configurable MQTT
+// topic prefix, for users wiring Tibber data straight into Home Assistant or
+// Node-RED without speaking FIMP.
+type MQTTJSONPublisher struct {
+	Client      mqtt.Client
+	TopicPrefix string
+}
+
+// NewMQTTJSONPublisher constructs a MQTTJSONPublisher.
+func NewMQTTJSONPublisher(client mqtt.Client, topicPrefix string) *MQTTJSONPublisher {
+	return &MQTTJSONPublisher{Client: client, TopicPrefix: topicPrefix}
+}
+
+type jsonValueReport struct {
+	HomeID string  `json:"home_id"`
+	Value  float64 `json:"value"`
+	Unit   string  `json:"unit,omitempty"`
+}
+
+func (p *MQTTJSONPublisher) PublishMeter(homeID string, service string, value float64, unit string, _ *fimpgo.FimpMessage) error {
+	return p.publish(service, jsonValueReport{HomeID: homeID, Value: value, Unit: unit})
+}
+
+func (p *MQTTJSONPublisher) PublishSensor(homeID string, service string, value float64, unit string, _ *fimpgo.FimpMessage) error {
+	return p.publish(service, jsonValueReport{HomeID: homeID, Value: value, Unit: unit})
+}
+
+func (p *MQTTJSONPublisher) PublishExtended(homeID string, service string, values map[string]float64, _ *fimpgo.FimpMessage) error {
+	return p.publish(service, map[string]interface{}{"home_id": homeID, "values": values})
+}
+
+func (p *MQTTJSONPublisher) publish(measurement string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	token := p.Client.Publish(p.TopicPrefix+"/"+measurement, 0, false, data)
+	token.Wait()
+	return token.Error()
+}
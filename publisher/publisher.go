@@ -0,0 +1,20 @@
+// Package publisher defines the output-sink abstraction the Tibber adapter
+// fans its meter/sensor events out to. FIMP is the primary consumer, but
+// additional sinks (plain MQTT JSON, InfluxDB) can be enabled from the app's
+// config so users can pipe live data into other tools without a bridge.
+// NewPublishersFromConfig (config.go) builds the enabled sinks from the
+// config file section; the app wires its parsed Config into it and passes
+// the result to NewTibberHandler as extraPublishers.
+package publisher
+
+import "github.com/futurehomeno/fimpgo"
+
+// Publisher is implemented by every output sink the adapter can report
+// meter, sensor and extended meter data to. oldMsg is the inbound command
+// message a report is correlated with, if any; sinks that don't have a
+// notion of request/response correlation (e.g. InfluxDB) simply ignore it.
+type Publisher interface {
+	PublishMeter(homeID string, service string, value float64, unit string, oldMsg *fimpgo.FimpMessage) error
+	PublishSensor(homeID string, service string, value float64, unit string, oldMsg *fimpgo.FimpMessage) error
+	PublishExtended(homeID string, service string, values map[string]float64, oldMsg *fimpgo.FimpMessage) error
+}